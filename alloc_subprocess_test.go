@@ -0,0 +1,114 @@
+package porter
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestHelperAllocChild is not a real test. It's re-invoked as a subprocess
+// by TestAllocNoOverlappingBlocks via os.Args[0], and prints the firstPort
+// of the block it was able to allocate so the parent can check for
+// collisions across processes.
+func TestHelperAllocChild(t *testing.T) {
+	if os.Getenv("PORTER_WANT_HELPER_PROCESS") != "1" {
+		t.Skip("skipping helper process; only runs as a subprocess of TestAllocNoOverlappingBlocks")
+	}
+
+	p, err := New(&Config{
+		BlockSize:     10,
+		MaxBlocks:     4,
+		LowerBound:    20000,
+		Attempts:      50,
+		LockDir:       os.Getenv("PORTER_TEST_LOCKDIR"),
+		RangeProvider: StaticProvider(1, 2),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "alloc failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	// hold the block for a moment so sibling processes have a chance to
+	// race for it before we release it.
+	fmt.Printf("FIRSTPORT=%d\n", p.firstPort)
+	time.Sleep(200 * time.Millisecond)
+	p.Close()
+}
+
+// TestAllocNoOverlappingBlocks spawns several sibling processes that each
+// allocate a port block against the same LockDir, and asserts that no two
+// of them were handed overlapping blocks. This exercises the flock-backed
+// coordination added on top of the listener-only guard.
+func TestAllocNoOverlappingBlocks(t *testing.T) {
+	if testing.Short() {
+		t.Skip("spawns subprocesses; skipped with -short")
+	}
+
+	lockDir := t.TempDir()
+
+	const children = 4
+	firstPorts := make([]int, children)
+	errs := make([]error, children)
+
+	var wg sync.WaitGroup
+	for i := 0; i < children; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			cmd := exec.Command(os.Args[0], "-test.run=^TestHelperAllocChild$")
+			cmd.Env = append(os.Environ(),
+				"PORTER_WANT_HELPER_PROCESS=1",
+				"PORTER_TEST_LOCKDIR="+lockDir,
+			)
+
+			out, err := cmd.Output()
+			if err != nil {
+				errs[i] = fmt.Errorf("child %d: %w", i, err)
+				return
+			}
+
+			firstPort, err := parseFirstPort(out)
+			if err != nil {
+				errs[i] = fmt.Errorf("child %d: %w", i, err)
+				return
+			}
+
+			firstPorts[i] = firstPort
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("child %d failed: %v", i, err)
+		}
+	}
+
+	seen := make(map[int]bool, children)
+	for i, port := range firstPorts {
+		if seen[port] {
+			t.Fatalf("child %d was handed firstPort %d, which another sibling already holds", i, port)
+		}
+		seen[port] = true
+	}
+}
+
+// parseFirstPort extracts the FIRSTPORT=<n> line printed by the helper
+// child process from its stdout.
+func parseFirstPort(out []byte) (int, error) {
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if rest, ok := strings.CutPrefix(line, "FIRSTPORT="); ok {
+			return strconv.Atoi(rest)
+		}
+	}
+	return 0, fmt.Errorf("no FIRSTPORT line in child output: %q", out)
+}
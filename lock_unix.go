@@ -0,0 +1,40 @@
+//go:build !windows
+
+package porter
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile is a held filesystem lock used to coordinate port block
+// selection across processes.
+type lockFile struct {
+	f *os.File
+}
+
+// lockBlock acquires an exclusive, non-blocking flock on path, creating the
+// file if necessary. It returns an error if the lock is already held by
+// another process.
+func lockBlock(path string) (*lockFile, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &lockFile{f: f}, nil
+}
+
+// unlock releases the flock and closes the underlying file.
+func (l *lockFile) unlock() error {
+	if err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
@@ -0,0 +1,44 @@
+//go:build windows
+
+package porter
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFile is a held filesystem lock used to coordinate port block
+// selection across processes.
+type lockFile struct {
+	f *os.File
+}
+
+// lockBlock acquires an exclusive, non-blocking LockFileEx lock on path,
+// creating the file if necessary. It returns an error if the lock is
+// already held by another process.
+func lockBlock(path string) (*lockFile, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	ol := new(windows.Overlapped)
+	flags := uint32(windows.LOCKFILE_FAIL_IMMEDIATELY | windows.LOCKFILE_EXCLUSIVE_LOCK)
+	if err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, ol); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &lockFile{f: f}, nil
+}
+
+// unlock releases the LockFileEx lock and closes the underlying file.
+func (l *lockFile) unlock() error {
+	ol := new(windows.Overlapped)
+	if err := windows.UnlockFileEx(windows.Handle(l.f.Fd()), 0, 1, 0, ol); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
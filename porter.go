@@ -1,23 +1,26 @@
 package porter
 
 import (
+	"container/list"
+	"context"
 	"fmt"
 	"math/rand"
 	"net"
-	"os/exec"
-	"regexp"
-	"strconv"
+	"os"
+	"path/filepath"
+	"runtime"
 	"sync"
 	"time"
-	"runtime"
 )
 
 // DefaultConfig is used to set reasonable config defaults.
 func DefaultConfig() *Config {
 	return &Config{
-		BlockSize:  100,
-		MaxBlocks:  10,
-		LowerBound: 10000,
+		BlockSize:          100,
+		MaxBlocks:          10,
+		LowerBound:         10000,
+		Attempts:           10,
+		PendingGracePeriod: 15 * time.Second,
 	}
 }
 
@@ -37,6 +40,141 @@ type Config struct {
 
 	// OS is used to override the ephemeral port range commands. Defaults to GOOS
 	OS string
+
+	// RangeProvider is used to override ephemeral port range detection
+	// entirely. If set, it takes precedence over OS/GOOS dispatch, which
+	// lets callers on unusual platforms (containers without sysctl,
+	// cross-compiled targets, tests) inject their own logic instead of
+	// shelling out. Defaults to the registered provider for runtime.GOOS.
+	RangeProvider EphemeralRangeProvider
+
+	// Attempts is the number of times alloc will retry against a different
+	// random block if either the listener or the cross-process lockfile
+	// can't be acquired for a candidate block.
+	Attempts int
+
+	// LockDir is the directory used to store the cross-process lockfiles
+	// that coordinate block selection between sibling Porter processes.
+	// Defaults to os.TempDir().
+	LockDir string
+
+	// Protocol configures which protocol(s) Porter verifies ports are free
+	// on before handing them out. Defaults to ProtoTCP.
+	Protocol Protocol
+
+	// Metrics, when set, receives counters and gauges for allocation
+	// activity and pool health. This lets callers bridge Porter to
+	// Prometheus, OpenTelemetry, expvar, or similar.
+	Metrics MetricsSink
+
+	// PendingGracePeriod is the minimum time a returned port must sit idle
+	// in pendingPorts before it's eligible for recycling back into
+	// freePorts, even once it passes the in-use probe. This guards
+	// against handing a port back out while it's still in TCP TIME_WAIT.
+	// Defaults to 15s.
+	PendingGracePeriod time.Duration
+}
+
+// MetricsSink receives counters and gauges describing Porter's allocation
+// activity and pool health.
+type MetricsSink interface {
+	IncCounter(name string)
+	SetGauge(name string, v float64)
+}
+
+// Metric names emitted to a configured MetricsSink.
+const (
+	MetricTotalTaken          = "porter.taken"
+	MetricTotalReturned       = "porter.returned"
+	MetricFailedTakes         = "porter.failed_takes"
+	MetricPortInUseRejections = "porter.port_in_use_rejections"
+	MetricBlockAllocRetries   = "porter.block_alloc_retries"
+	MetricFreeCount           = "porter.free_count"
+	MetricPendingCount        = "porter.pending_count"
+)
+
+// Stats is a point-in-time snapshot of a Porter's allocation counters and
+// pool health, mirroring what's emitted to a configured MetricsSink.
+type Stats struct {
+	// TotalTaken is the number of ports handed out by Take/TakeContext.
+	TotalTaken int
+
+	// TotalReturned is the number of ports handed back via Return.
+	TotalReturned int
+
+	// PendingCount is the number of ports awaiting recycling.
+	PendingCount int
+
+	// FreeCount is the number of ports immediately available to Take.
+	FreeCount int
+
+	// FailedTakes is the number of Take calls that failed outright
+	// because too few ports were free.
+	FailedTakes int
+
+	// PortInUseRejections is the number of ports discarded from freePorts
+	// by Take/TakeContext because they were found to be in use. Pending
+	// ports that fail the probe stay pending and are not counted.
+	PortInUseRejections int
+
+	// BlockAllocRetries is the number of candidate blocks alloc gave up
+	// on because the listener, UDP conn, or lockfile couldn't be
+	// acquired, including the last one when every attempt fails.
+	BlockAllocRetries int
+}
+
+// Protocol identifies the network protocol(s) a port must be free on before
+// Porter will consider it free.
+type Protocol int
+
+const (
+	// ProtoTCP verifies ports are free on TCP only. This is the default.
+	ProtoTCP Protocol = iota
+
+	// ProtoUDP verifies ports are free on UDP only.
+	ProtoUDP
+
+	// ProtoBoth verifies ports are free on both TCP and UDP simultaneously.
+	ProtoBoth
+)
+
+// EphemeralRangeProvider reports the host's ephemeral port range as a
+// min/max pair.
+type EphemeralRangeProvider interface {
+	EphemeralPortRange() (int, int, error)
+}
+
+// defaultProviders holds the EphemeralRangeProvider registered for each
+// GOOS. OS-specific files register themselves here via init().
+var defaultProviders = map[string]EphemeralRangeProvider{}
+
+// registerDefaultProvider is called from OS-specific files to wire up the
+// default EphemeralRangeProvider for a given GOOS.
+func registerDefaultProvider(goos string, provider EphemeralRangeProvider) {
+	defaultProviders[goos] = provider
+}
+
+// staticProvider is an EphemeralRangeProvider that always reports a fixed
+// min/max pair.
+type staticProvider struct {
+	min, max int
+}
+
+// StaticProvider returns an EphemeralRangeProvider that always reports the
+// given min/max pair, bypassing OS detection entirely.
+func StaticProvider(min, max int) EphemeralRangeProvider {
+	return &staticProvider{min: min, max: max}
+}
+
+func (s *staticProvider) EphemeralPortRange() (int, int, error) {
+	return s.min, s.max, nil
+}
+
+// pendingEntry pairs a returned port with the time it was returned, so
+// checkFreedPorts can enforce Config.PendingGracePeriod before recycling it.
+type pendingEntry struct {
+	port       int
+	returnedAt time.Time
 }
 
 // Porter is used to track free ports.
@@ -50,20 +188,43 @@ type Porter struct {
 	// firstIP is the first IP of the allocated block
 	firstPort int
 
-	// freePorts is the list of ports _we know_ to be free
-	freePorts []int
+	// freePorts is the FIFO of ports _we know_ to be free. Ports are taken
+	// from the front and recycled onto the back, so reuse is LRU rather
+	// than LIFO.
+	freePorts *list.List
 
-	// pendingPorts is the list of ports _we believe_ to be free
-	pendingPorts []int
+	// pendingPorts is the FIFO of pendingEntry values for ports _we
+	// believe_ to be free, oldest (front) to newest (back).
+	pendingPorts *list.List
 
 	// ln is used to reserve the port block on the host
 	ln net.Listener
 
+	// pc is used to additionally reserve the block's sentinel port over
+	// UDP when the configured Protocol is ProtoBoth.
+	pc *net.UDPConn
+
+	// lock is the cross-process filesystem lock that backs up ln to
+	// guard against listener races between sibling processes.
+	lock *lockFile
+
 	// mu is used to force synchronous edits on the port lists
 	mu sync.Mutex
 
+	// cond is signalled whenever checkFreedPorts promotes a pending port
+	// to free, waking any goroutines blocked in TakeContext.
+	cond *sync.Cond
+
 	// stopCh is used to stop the pending port checker
 	stopCh chan struct{}
+
+	// totalTaken, totalReturned, failedTakes, portInUseRejections, and
+	// blockAllocRetries back Stats(). Guarded by mu.
+	totalTaken          int
+	totalReturned       int
+	failedTakes         int
+	portInUseRejections int
+	blockAllocRetries   int
 }
 
 // New creates a new Porter object. It returns an error if porter is unable to
@@ -71,11 +232,12 @@ type Porter struct {
 func New(config *Config) (*Porter, error) {
 	p := &Porter{
 		config:             config,
-		freePorts:          make([]int, 0),
-		pendingPorts:       make([]int, 0),
+		freePorts:          list.New(),
+		pendingPorts:       list.New(),
 		effectiveMaxBlocks: config.MaxBlocks,
 		stopCh:             make(chan struct{}),
 	}
+	p.cond = sync.NewCond(&p.mu)
 
 	if err := p.adjustMaxBlocks(); err != nil {
 		return nil, err
@@ -91,34 +253,84 @@ func New(config *Config) (*Porter, error) {
 
 	// Allocate a port block
 	rand.Seed(time.Now().UnixNano())
-	p.alloc()
+	if err := p.alloc(); err != nil {
+		return nil, err
+	}
 
 	// Select free ports from the allocated port block
 	for port := p.firstPort + 1; port < p.firstPort+config.BlockSize; port++ {
-		if used := IsPortInUse(port); !used {
-			p.freePorts = append(p.freePorts, port)
+		if used := IsPortInUseProto(port, config.Protocol); !used {
+			p.freePorts.PushBack(port)
 		}
 	}
 
 	return p, nil
 }
 
-// alloc is used to allocate a new port block and take out a listener lock.
+// alloc is used to allocate a new port block and take out both a listener
+// lock and a cross-process filesystem lock. If either can't be acquired for
+// a candidate block, a different random block is tried up to
+// Config.Attempts times.
 func (p *Porter) alloc() error {
-	fmt.Println(int32(p.effectiveMaxBlocks))
+	attempts := p.config.Attempts
+	if attempts <= 0 {
+		attempts = 1
+	}
 
-	// grab a random first port from the effective block range
-	block := int(rand.Int31n(int32(p.effectiveMaxBlocks)))
-	first := p.config.LowerBound + (block * p.config.BlockSize)
+	var err error
+	for i := 0; i < attempts; i++ {
+		// grab a random first port from the effective block range
+		block := int(rand.Int31n(int32(p.effectiveMaxBlocks)))
+		first := p.config.LowerBound + (block * p.config.BlockSize)
+
+		// lock the port by taking out a listener. This must be freed.
+		var ln net.Listener
+		ln, err = net.ListenTCP("tcp", TCPAddr("127.0.0.1", first))
+		if err != nil {
+			p.recordAllocRetry()
+			continue
+		}
 
-	// lock the port by taking out a listener. This must be freed.
-	ln, err := net.ListenTCP("tcp", TCPAddr("127.0.0.1", first))
-	if err != nil {
-		return err
+		// in dual-stack mode, also hold the sentinel port over UDP so a
+		// TCP-only block isn't mistaken for a free dual-stack one.
+		var pc *net.UDPConn
+		if p.config.Protocol == ProtoBoth {
+			pc, err = net.ListenUDP("udp", UDPAddr("127.0.0.1", first))
+			if err != nil {
+				ln.Close()
+				p.recordAllocRetry()
+				continue
+			}
+		}
+
+		// also take out a system-wide lockfile, in case the listener
+		// races with a sibling process. This must be freed too.
+		var lock *lockFile
+		lock, err = lockBlock(p.lockPath(first))
+		if err != nil {
+			ln.Close()
+			if pc != nil {
+				pc.Close()
+			}
+			p.recordAllocRetry()
+			continue
+		}
+
+		p.firstPort, p.ln, p.pc, p.lock = first, ln, pc, lock
+		return nil
 	}
 
-	p.firstPort, p.ln = first, ln
-	return nil
+	return fmt.Errorf("unable to allocate a port block after %d attempts: %w", attempts, err)
+}
+
+// lockPath returns the path of the system-wide lockfile used to coordinate
+// selection of the block starting at first.
+func (p *Porter) lockPath(first int) string {
+	dir := p.config.LockDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, fmt.Sprintf("porter-block-%d.lock", first))
 }
 
 // adjustMaxBlocks checks for block overlap with the ephemeral port range. If
@@ -144,28 +356,86 @@ func (p *Porter) adjustMaxBlocks() error {
 	return nil
 }
 
-// Take is used to take a list of free ports.
+// Take is used to take a list of free ports. It fails immediately with
+// "Block size too small" if fewer than n ports are currently free, even if
+// pending ports are about to be recycled. Use TakeContext to block instead.
 func (p *Porter) Take(n int) ([]int, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if n > len(p.freePorts) {
+	if n > p.freePorts.Len() {
+		p.failedTakes++
+		p.incCounter(MetricFailedTakes)
 		return nil, fmt.Errorf("Block size too small")
 	}
-	ports := make([]int, 0)
+
+	return p.takeLocked(n), nil
+}
+
+// TakeContext is used to take a list of free ports, blocking until n ports
+// are free or ctx is cancelled. checkFreedPorts broadcasts p.cond whenever
+// it promotes a pending port to free, waking any waiters to re-check.
+func (p *Porter) TakeContext(ctx context.Context, n int) ([]int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for n > p.freePorts.Len() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// sync.Cond has no context support, so wake our own Wait if ctx
+		// is cancelled while we're blocked on it. Taking p.mu first
+		// ensures the broadcast can't land before Wait has registered.
+		woken := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				p.mu.Lock()
+				p.cond.Broadcast()
+				p.mu.Unlock()
+			case <-woken:
+			}
+		}()
+
+		p.cond.Wait()
+		close(woken)
+	}
+
+	return p.takeLocked(n), nil
+}
+
+// TakeTimeout is a convenience wrapper around TakeContext that gives up
+// after d.
+func (p *Porter) TakeTimeout(d time.Duration, n int) ([]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	return p.TakeContext(ctx, n)
+}
+
+// takeLocked drains n verified-free ports from the front of freePorts.
+// p.mu must be held by the caller.
+func (p *Porter) takeLocked(n int) []int {
+	ports := make([]int, 0, n)
 
 	for len(ports) < n {
-		port := p.freePorts[0]
-		p.freePorts = p.freePorts[1:]
+		port := p.freePorts.Remove(p.freePorts.Front()).(int)
 
-		if used := IsPortInUse(port); used {
+		if used := IsPortInUseProto(port, p.config.Protocol); used {
+			p.portInUseRejections++
+			p.incCounter(MetricPortInUseRejections)
 			continue
 		}
 
+		p.totalTaken++
+		p.incCounter(MetricTotalTaken)
 		ports = append(ports, port)
 	}
 
-	return ports, nil
+	p.setGauge(MetricFreeCount, float64(p.freePorts.Len()))
+
+	return ports
 }
 
 // MustTake is used to take a list of free ports, and panics if there's an
@@ -187,21 +457,50 @@ func (p *Porter) Return(ports []int) {
 	defer p.mu.Unlock()
 
 	for _, port := range ports {
-		if port > p.firstPort && port < p.firstPort*p.config.BlockSize {
-			p.pendingPorts = append(p.pendingPorts, port)
+		if port > p.firstPort && port < p.firstPort+p.config.BlockSize {
+			p.pendingPorts.PushBack(pendingEntry{port: port, returnedAt: time.Now()})
+			p.totalReturned++
+			p.incCounter(MetricTotalReturned)
 		}
 	}
+
+	p.setGauge(MetricPendingCount, float64(p.pendingPorts.Len()))
 }
 
+// checkFreedPorts promotes pending ports back to freePorts once they've
+// both sat idle for at least Config.PendingGracePeriod and pass the in-use
+// probe. pendingPorts is walked oldest-first, so once an entry hasn't yet
+// cleared the grace period, nothing behind it has either.
 func (p *Porter) checkFreedPorts() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	for i, port := range p.pendingPorts {
-		if used := IsPortInUse(port); !used {
-			p.freePorts = append(p.freePorts, port)
-			p.pendingPorts = append(p.pendingPorts[:i], p.pendingPorts[i+1:]...)
+	grace := p.config.PendingGracePeriod
+	now := time.Now()
+	promoted := false
+
+	var next *list.Element
+	for e := p.pendingPorts.Front(); e != nil; e = next {
+		next = e.Next()
+		entry := e.Value.(pendingEntry)
+
+		if now.Sub(entry.returnedAt) < grace {
+			break
+		}
+
+		if used := IsPortInUseProto(entry.port, p.config.Protocol); used {
+			continue
 		}
+
+		p.pendingPorts.Remove(e)
+		p.freePorts.PushBack(entry.port)
+		promoted = true
+	}
+
+	if promoted {
+		p.setGauge(MetricFreeCount, float64(p.freePorts.Len()))
+		p.setGauge(MetricPendingCount, float64(p.pendingPorts.Len()))
+		p.cond.Broadcast()
 	}
 }
 
@@ -217,8 +516,8 @@ func (p *Porter) CheckFreedPorts() {
 	}
 }
 
-// Close is used to close the listener that locks the first port of the
-// allocated block.
+// Close is used to close the listener and filesystem lock that lock the
+// first port of the allocated block.
 func (p *Porter) Close() {
 	defer close(p.stopCh)
 
@@ -226,83 +525,88 @@ func (p *Porter) Close() {
 		p.ln.Close()
 		p.ln = nil
 	}
-}
-
-// TCPAddr is used to initialize a net.TCPAddr from a given ip/port string/int.
-func TCPAddr(ip string, port int) *net.TCPAddr {
-	return &net.TCPAddr{IP: net.ParseIP(ip), Port: port}
-}
 
-func (p *Porter) ephemeralPortRange() (int, int, error) {
-	os := runtime.GOOS
-	if p.config.OS != "" {
-		os = p.config.OS
+	if p.pc != nil {
+		p.pc.Close()
+		p.pc = nil
 	}
 
-	switch os {
-	case "darwin":
-		return darwinEmphemeralPortRange()
+	if p.lock != nil {
+		p.lock.unlock()
+		p.lock = nil
+	}
+}
 
-	case "linux":
-		return linuxEphemeralPortRange()
+// Stats returns a point-in-time snapshot of Porter's allocation counters
+// and pool health.
+func (p *Porter) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	default:
-		return 0, 0, fmt.Errorf("Supported OS %s", os)
+	return Stats{
+		TotalTaken:          p.totalTaken,
+		TotalReturned:       p.totalReturned,
+		PendingCount:        p.pendingPorts.Len(),
+		FreeCount:           p.freePorts.Len(),
+		FailedTakes:         p.failedTakes,
+		PortInUseRejections: p.portInUseRejections,
+		BlockAllocRetries:   p.blockAllocRetries,
 	}
 }
 
-// ephemeralPortRange is used to get the host systems's ephemeral port range.
-// This function is a bit of hack, and needs to be expanded to support Darwin
-// and Windows.
-func linuxEphemeralPortRange() (int, int, error) {
-	key := "net.ipv4.ip_local_port_range"
-	pattern := regexp.MustCompile(`^\s*(\d+)\s+(\d+)\s*$`)
-
-	cmd := exec.Command("sysctl", "-n", key)
-	out, err := cmd.Output()
-	if err != nil {
-		return 0, 0, err
-	}
+// recordAllocRetry tracks a failed alloc attempt against a candidate block.
+func (p *Porter) recordAllocRetry() {
+	p.mu.Lock()
+	p.blockAllocRetries++
+	p.mu.Unlock()
 
-	val := string(out)
+	p.incCounter(MetricBlockAllocRetries)
+}
 
-	m := pattern.FindStringSubmatch(val)
-	if m != nil {
-		min, err1 := strconv.Atoi(m[1])
-		max, err2 := strconv.Atoi(m[2])
+// incCounter forwards a counter increment to the configured MetricsSink, if
+// any.
+func (p *Porter) incCounter(name string) {
+	if p.config.Metrics != nil {
+		p.config.Metrics.IncCounter(name)
+	}
+}
 
-		if err1 == nil && err2 == nil {
-			return min, max, nil
-		}
+// setGauge forwards a gauge update to the configured MetricsSink, if any.
+func (p *Porter) setGauge(name string, v float64) {
+	if p.config.Metrics != nil {
+		p.config.Metrics.SetGauge(name, v)
 	}
+}
 
-	return 0, 0, fmt.Errorf("Unexpected sysctl value %q.", val)
+// TCPAddr is used to initialize a net.TCPAddr from a given ip/port string/int.
+func TCPAddr(ip string, port int) *net.TCPAddr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: port}
 }
 
-func darwinEmphemeralPortRange() (int, int, error) {
-	firstKey := "net.inet.ip.portrange.first"
-	lastKey := "net.inet.ip.portrange.last"
-	pattern := regexp.MustCompile(`^\s*(\d+)\s+(\d+)\s*$`)
+// UDPAddr is used to initialize a net.UDPAddr from a given ip/port string/int.
+func UDPAddr(ip string, port int) *net.UDPAddr {
+	return &net.UDPAddr{IP: net.ParseIP(ip), Port: port}
+}
 
-	cmd := exec.Command("sysctl", "-n", firstKey, lastKey)
-	out, err := cmd.Output()
-	if err != nil {
-		return 0, 0, err
+// ephemeralPortRange is used to get the host systems's ephemeral port range.
+// It defers to p.config.RangeProvider when set, and otherwise dispatches to
+// the EphemeralRangeProvider registered for p.config.OS (or runtime.GOOS).
+func (p *Porter) ephemeralPortRange() (int, int, error) {
+	if p.config.RangeProvider != nil {
+		return p.config.RangeProvider.EphemeralPortRange()
 	}
 
-	val := string(out)
-
-	m := pattern.FindStringSubmatch(val)
-	if m != nil {
-		min, err1 := strconv.Atoi(m[1])
-		max, err2 := strconv.Atoi(m[2])
+	os := runtime.GOOS
+	if p.config.OS != "" {
+		os = p.config.OS
+	}
 
-		if err1 == nil && err2 == nil {
-			return min, max, nil
-		}
+	provider, ok := defaultProviders[os]
+	if !ok {
+		return 0, 0, fmt.Errorf("Unsupported OS %s", os)
 	}
 
-	return 0, 0, fmt.Errorf("Unexpected sysctl value %q.", val)
+	return provider.EphemeralPortRange()
 }
 
 // rangeOverlap is a predicate used to check if the two min-max pairs overload.
@@ -317,12 +621,30 @@ func rangeOverlap(min1, max1, min2, max2 int) bool {
 }
 
 // IsPortInUse is a predicate used to check if a process is already bound to
-// given port.
+// given port over TCP.
 func IsPortInUse(port int) bool {
-	ln, err := net.ListenTCP("tcp", TCPAddr("127.0.0.1", port))
-	if err != nil {
-		return true
+	return IsPortInUseProto(port, ProtoTCP)
+}
+
+// IsPortInUseProto is a predicate used to check if a process is already
+// bound to a given port over the given protocol(s). ProtoBoth requires the
+// port to be free on both TCP and UDP.
+func IsPortInUseProto(port int, proto Protocol) bool {
+	if proto == ProtoTCP || proto == ProtoBoth {
+		ln, err := net.ListenTCP("tcp", TCPAddr("127.0.0.1", port))
+		if err != nil {
+			return true
+		}
+		_ = ln.Close()
 	}
-	_ = ln.Close()
+
+	if proto == ProtoUDP || proto == ProtoBoth {
+		pc, err := net.ListenUDP("udp", UDPAddr("127.0.0.1", port))
+		if err != nil {
+			return true
+		}
+		_ = pc.Close()
+	}
+
 	return false
 }
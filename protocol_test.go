@@ -0,0 +1,87 @@
+package porter
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIsPortInUseProto(t *testing.T) {
+	ln, err := net.ListenTCP("tcp", TCPAddr("127.0.0.1", 0))
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	if !IsPortInUseProto(port, ProtoTCP) {
+		t.Fatalf("expected port %d to be reported in use over TCP", port)
+	}
+	if !IsPortInUseProto(port, ProtoBoth) {
+		t.Fatalf("expected port %d to be reported in use over ProtoBoth once TCP is bound", port)
+	}
+	if IsPortInUseProto(port, ProtoUDP) {
+		t.Fatalf("expected port %d to be free over UDP despite being bound over TCP", port)
+	}
+}
+
+func TestNewDualStackHoldsUDPSentinel(t *testing.T) {
+	p, err := New(&Config{
+		BlockSize:     10,
+		MaxBlocks:     4,
+		LowerBound:    21000,
+		Attempts:      50,
+		LockDir:       t.TempDir(),
+		RangeProvider: StaticProvider(1, 2),
+		Protocol:      ProtoBoth,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer p.Close()
+
+	if p.pc == nil {
+		t.Fatalf("expected alloc to hold a UDP packet conn on the sentinel port in ProtoBoth mode")
+	}
+
+	if !IsPortInUseProto(p.firstPort, ProtoUDP) {
+		t.Fatalf("expected sentinel port %d to be in use over UDP", p.firstPort)
+	}
+}
+
+func TestTakeUDPOnlyIgnoresTCPUsage(t *testing.T) {
+	p, err := New(&Config{
+		BlockSize:     10,
+		MaxBlocks:     4,
+		LowerBound:    21200,
+		Attempts:      50,
+		LockDir:       t.TempDir(),
+		RangeProvider: StaticProvider(1, 2),
+		Protocol:      ProtoUDP,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer p.Close()
+
+	// occupy every free port's TCP side; if Take were still checking TCP
+	// in ProtoUDP mode it would reject all of them.
+	var lns []*net.TCPListener
+	p.mu.Lock()
+	for e := p.freePorts.Front(); e != nil; e = e.Next() {
+		ln, err := net.ListenTCP("tcp", TCPAddr("127.0.0.1", e.Value.(int)))
+		if err == nil {
+			lns = append(lns, ln)
+		}
+	}
+	p.mu.Unlock()
+	defer func() {
+		for _, ln := range lns {
+			ln.Close()
+		}
+	}()
+
+	if _, err := p.Take(1); err != nil {
+		t.Fatalf("Take should ignore TCP occupancy in ProtoUDP mode: %v", err)
+	}
+}
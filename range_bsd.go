@@ -0,0 +1,51 @@
+//go:build freebsd || openbsd || netbsd || dragonfly
+
+package porter
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+)
+
+func init() {
+	registerDefaultProvider(runtime.GOOS, bsdRangeProvider{})
+}
+
+// bsdRangeProvider is the default EphemeralRangeProvider for the *BSD
+// family (freebsd, openbsd, netbsd, dragonfly).
+type bsdRangeProvider struct{}
+
+func (bsdRangeProvider) EphemeralPortRange() (int, int, error) {
+	return bsdEphemeralPortRange()
+}
+
+// bsdEphemeralPortRange is used to get the host systems's ephemeral port
+// range via sysctl, same keys as darwin.
+func bsdEphemeralPortRange() (int, int, error) {
+	firstKey := "net.inet.ip.portrange.first"
+	lastKey := "net.inet.ip.portrange.last"
+	pattern := regexp.MustCompile(`^\s*(\d+)\s+(\d+)\s*$`)
+
+	cmd := exec.Command("sysctl", "-n", firstKey, lastKey)
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	val := string(out)
+
+	m := pattern.FindStringSubmatch(val)
+	if m != nil {
+		min, err1 := strconv.Atoi(m[1])
+		max, err2 := strconv.Atoi(m[2])
+
+		if err1 == nil && err2 == nil {
+			return min, max, nil
+		}
+	}
+
+	return 0, 0, fmt.Errorf("Unexpected sysctl value %q.", val)
+}
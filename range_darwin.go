@@ -0,0 +1,47 @@
+package porter
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+func init() {
+	registerDefaultProvider("darwin", darwinRangeProvider{})
+}
+
+// darwinRangeProvider is the default EphemeralRangeProvider for darwin.
+type darwinRangeProvider struct{}
+
+func (darwinRangeProvider) EphemeralPortRange() (int, int, error) {
+	return darwinEmphemeralPortRange()
+}
+
+// darwinEmphemeralPortRange is used to get the host systems's ephemeral
+// port range via sysctl.
+func darwinEmphemeralPortRange() (int, int, error) {
+	firstKey := "net.inet.ip.portrange.first"
+	lastKey := "net.inet.ip.portrange.last"
+	pattern := regexp.MustCompile(`^\s*(\d+)\s+(\d+)\s*$`)
+
+	cmd := exec.Command("sysctl", "-n", firstKey, lastKey)
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	val := string(out)
+
+	m := pattern.FindStringSubmatch(val)
+	if m != nil {
+		min, err1 := strconv.Atoi(m[1])
+		max, err2 := strconv.Atoi(m[2])
+
+		if err1 == nil && err2 == nil {
+			return min, max, nil
+		}
+	}
+
+	return 0, 0, fmt.Errorf("Unexpected sysctl value %q.", val)
+}
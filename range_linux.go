@@ -0,0 +1,46 @@
+package porter
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+func init() {
+	registerDefaultProvider("linux", linuxRangeProvider{})
+}
+
+// linuxRangeProvider is the default EphemeralRangeProvider for linux.
+type linuxRangeProvider struct{}
+
+func (linuxRangeProvider) EphemeralPortRange() (int, int, error) {
+	return linuxEphemeralPortRange()
+}
+
+// linuxEphemeralPortRange is used to get the host systems's ephemeral port
+// range via sysctl.
+func linuxEphemeralPortRange() (int, int, error) {
+	key := "net.ipv4.ip_local_port_range"
+	pattern := regexp.MustCompile(`^\s*(\d+)\s+(\d+)\s*$`)
+
+	cmd := exec.Command("sysctl", "-n", key)
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	val := string(out)
+
+	m := pattern.FindStringSubmatch(val)
+	if m != nil {
+		min, err1 := strconv.Atoi(m[1])
+		max, err2 := strconv.Atoi(m[2])
+
+		if err1 == nil && err2 == nil {
+			return min, max, nil
+		}
+	}
+
+	return 0, 0, fmt.Errorf("Unexpected sysctl value %q.", val)
+}
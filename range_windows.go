@@ -0,0 +1,49 @@
+package porter
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+func init() {
+	registerDefaultProvider("windows", windowsRangeProvider{})
+}
+
+// windowsRangeProvider is the default EphemeralRangeProvider for windows.
+type windowsRangeProvider struct{}
+
+func (windowsRangeProvider) EphemeralPortRange() (int, int, error) {
+	return windowsEphemeralPortRange()
+}
+
+// windowsEphemeralPortRange is used to get the host system's ephemeral port
+// range via netsh. Unlike sysctl, netsh reports a start port and a count of
+// ports rather than a min/max pair, so the two are converted here.
+func windowsEphemeralPortRange() (int, int, error) {
+	startPattern := regexp.MustCompile(`Start Port\s*:\s*(\d+)`)
+	numPattern := regexp.MustCompile(`Number of Ports\s*:\s*(\d+)`)
+
+	cmd := exec.Command("netsh", "int", "ipv4", "show", "dynamicport", "tcp")
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	val := string(out)
+
+	startMatch := startPattern.FindStringSubmatch(val)
+	numMatch := numPattern.FindStringSubmatch(val)
+	if startMatch == nil || numMatch == nil {
+		return 0, 0, fmt.Errorf("Unexpected netsh output %q.", val)
+	}
+
+	start, err1 := strconv.Atoi(startMatch[1])
+	num, err2 := strconv.Atoi(numMatch[1])
+	if err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("Unexpected netsh output %q.", val)
+	}
+
+	return start, start + num - 1, nil
+}
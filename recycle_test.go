@@ -0,0 +1,84 @@
+package porter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckFreedPortsEnforcesGracePeriod(t *testing.T) {
+	p := newTestPorter(t, 24000, func(c *Config) {
+		c.PendingGracePeriod = 100 * time.Millisecond
+	})
+
+	// drain every free port first so the only port left to hand out after
+	// recycling is the one we return below.
+	all, err := p.Take(p.Stats().FreeCount)
+	if err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+
+	taken := all[:1]
+	port := taken[0]
+
+	p.Return(taken)
+
+	// immediately after return, the grace period hasn't elapsed yet, so
+	// the port must stay pending even though it passes the in-use probe.
+	p.checkFreedPorts()
+	if got := p.Stats().PendingCount; got != 1 {
+		t.Fatalf("expected port to remain pending before the grace period elapses, got PendingCount=%d", got)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	p.checkFreedPorts()
+	stats := p.Stats()
+	if stats.PendingCount != 0 {
+		t.Fatalf("expected port to be promoted once the grace period elapses, got PendingCount=%d", stats.PendingCount)
+	}
+	if stats.FreeCount == 0 {
+		t.Fatalf("expected promoted port to land back in freePorts")
+	}
+
+	again, err := p.Take(1)
+	if err != nil {
+		t.Fatalf("Take after recycle: %v", err)
+	}
+	if again[0] != port {
+		t.Fatalf("expected the single recycled port %d to be reissued, got %d", port, again[0])
+	}
+}
+
+func TestFreePortsRecycleLRU(t *testing.T) {
+	p := newTestPorter(t, 24100, func(c *Config) {
+		c.PendingGracePeriod = 0
+	})
+
+	// drain the whole pool so freePorts/pendingPorts start empty; any
+	// ports Take hands back after this are only the ones we recycle
+	// below, not leftovers from the initial free list.
+	all, err := p.Take(p.Stats().FreeCount)
+	if err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	if len(all) < 2 {
+		t.Skip("not enough free ports in this environment to exercise LRU ordering")
+	}
+
+	// return two ports in a known order, as two separate Return calls so
+	// each gets its own returnedAt timestamp, then recycle both at once.
+	// LIFO reissue would hand them back reversed; FIFO (LRU) reissues
+	// them in the same order they were returned.
+	p.Return(all[:1])
+	p.Return(all[1:2])
+	p.checkFreedPorts()
+
+	again, err := p.Take(2)
+	if err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	if again[0] != all[0] || again[1] != all[1] {
+		t.Fatalf("expected LRU (FIFO) recycling to reissue ports in return order [%d %d], got [%d %d]",
+			all[0], all[1], again[0], again[1])
+	}
+}
@@ -0,0 +1,66 @@
+package porter
+
+import "testing"
+
+// fakeMetricsSink records every counter/gauge call so tests can assert on
+// what Porter emitted, without needing a real Prometheus/expvar backend.
+type fakeMetricsSink struct {
+	counters map[string]int
+	gauges   map[string]float64
+}
+
+func newFakeMetricsSink() *fakeMetricsSink {
+	return &fakeMetricsSink{
+		counters: make(map[string]int),
+		gauges:   make(map[string]float64),
+	}
+}
+
+func (f *fakeMetricsSink) IncCounter(name string) {
+	f.counters[name]++
+}
+
+func (f *fakeMetricsSink) SetGauge(name string, v float64) {
+	f.gauges[name] = v
+}
+
+func TestStatsAndMetricsSink(t *testing.T) {
+	sink := newFakeMetricsSink()
+
+	p := newTestPorter(t, 23000, func(c *Config) {
+		c.Metrics = sink
+	})
+
+	taken, err := p.Take(2)
+	if err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+
+	stats := p.Stats()
+	if stats.TotalTaken != 2 {
+		t.Fatalf("expected TotalTaken=2, got %d", stats.TotalTaken)
+	}
+	if sink.counters[MetricTotalTaken] != 2 {
+		t.Fatalf("expected %s counter=2, got %d", MetricTotalTaken, sink.counters[MetricTotalTaken])
+	}
+
+	p.Return(taken)
+
+	stats = p.Stats()
+	if stats.TotalReturned != 2 {
+		t.Fatalf("expected TotalReturned=2, got %d", stats.TotalReturned)
+	}
+	if stats.PendingCount != 2 {
+		t.Fatalf("expected PendingCount=2, got %d", stats.PendingCount)
+	}
+	if sink.counters[MetricTotalReturned] != 2 {
+		t.Fatalf("expected %s counter=2, got %d", MetricTotalReturned, sink.counters[MetricTotalReturned])
+	}
+
+	if _, err := p.Take(1000); err == nil {
+		t.Fatal("expected an oversized Take to fail")
+	}
+	if sink.counters[MetricFailedTakes] != 1 {
+		t.Fatalf("expected %s counter=1, got %d", MetricFailedTakes, sink.counters[MetricFailedTakes])
+	}
+}
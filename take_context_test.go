@@ -0,0 +1,99 @@
+package porter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// newTestPorter builds a Porter against a small, isolated block range so
+// tests don't depend on (or collide over) the host's real ephemeral range.
+func newTestPorter(t *testing.T, lowerBound int, configure func(*Config)) *Porter {
+	t.Helper()
+
+	c := &Config{
+		BlockSize:     10,
+		MaxBlocks:     4,
+		LowerBound:    lowerBound,
+		Attempts:      50,
+		LockDir:       t.TempDir(),
+		RangeProvider: StaticProvider(1, 2),
+	}
+	if configure != nil {
+		configure(c)
+	}
+
+	p, err := New(c)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+func TestTakeFailsFastWhenNotEnoughFree(t *testing.T) {
+	p := newTestPorter(t, 22000, nil)
+
+	free := p.Stats().FreeCount
+	if _, err := p.Take(free + 1); err == nil {
+		t.Fatalf("expected Take to fail immediately when requesting more ports than are free")
+	}
+}
+
+func TestTakeContextUnblocksOnRecycledPort(t *testing.T) {
+	p := newTestPorter(t, 22100, func(c *Config) {
+		c.PendingGracePeriod = time.Millisecond
+	})
+
+	free := p.Stats().FreeCount
+	taken, err := p.Take(free)
+	if err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+
+	resultCh := make(chan []int, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		ports, err := p.TakeContext(ctx, 1)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		resultCh <- ports
+	}()
+
+	// give the goroutine a moment to start blocking on the cond var.
+	time.Sleep(20 * time.Millisecond)
+
+	p.Return(taken[:1])
+	time.Sleep(5 * time.Millisecond)
+	p.checkFreedPorts()
+
+	select {
+	case ports := <-resultCh:
+		if len(ports) != 1 {
+			t.Fatalf("expected 1 port, got %d", len(ports))
+		}
+	case err := <-errCh:
+		t.Fatalf("TakeContext returned error: %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("TakeContext did not unblock after a port was recycled")
+	}
+}
+
+func TestTakeTimeoutExpires(t *testing.T) {
+	p := newTestPorter(t, 22200, nil)
+
+	free := p.Stats().FreeCount
+	if _, err := p.Take(free); err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+
+	if _, err := p.TakeTimeout(50*time.Millisecond, 1); err == nil {
+		t.Fatal("expected TakeTimeout to return an error once the context deadline is exceeded")
+	}
+}